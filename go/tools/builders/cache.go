@@ -0,0 +1,263 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// cache.go persists analyzer findings under -cache-dir, keyed by a
+// content hash of everything that could change the outcome of an analyzer
+// run: the package's source, the export data of its imports, the resolved
+// -config file, and a stamp of the checker binary itself. Re-running the
+// checker over byte-identical inputs (the common case for an incremental
+// Bazel build where only a few leaf packages changed) then skips running
+// the analyzer entirely.
+//
+// Only analyzers with no FactTypes whose result no other registered
+// analyzer Requires are cached. Those are the ones whose entire
+// contribution to a run is the findings they report: nothing needs their
+// typed analysis.Pass result, which can't be round-tripped through gob,
+// and nothing needs their facts, which are attributed to the exporting
+// analyzer only implicitly via the shared factSet. Analyzers that
+// participate in the Requires graph or export facts always run.
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"golang.org/x/tools/go/analysis"
+)
+
+// analysisCache reads and writes namespaced, content-addressed entry files
+// under dir, so that concurrent Bazel actions sharing a cache directory
+// never observe a partially written entry: each entry is written to a
+// temporary file and atomically renamed into place.
+type analysisCache struct {
+	dir        string
+	binaryHash string
+}
+
+// hashExecutable sha256-hashes the running checker binary, so that a
+// rebuild (e.g. to fix a buggy analyzer) is distinguishable from the
+// previous build: newAnalysisCache uses it to invalidate every cache
+// entry for a stale binary, and printUnitcheckerVersion uses it as the
+// buildID `go vet -vettool=` hashes for its own result caching.
+func hashExecutable() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("resolving checker binary: %v", err)
+	}
+	data, err := ioutil.ReadFile(exe)
+	if err != nil {
+		return "", fmt.Errorf("reading checker binary: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// newAnalysisCache hashes the running checker binary once, so that a
+// checker rebuild (e.g. to fix a buggy analyzer) invalidates every entry
+// for it even though the packages it analyzes haven't changed.
+func newAnalysisCache(dir string) (*analysisCache, error) {
+	hash, err := hashExecutable()
+	if err != nil {
+		return nil, err
+	}
+	return &analysisCache{dir: dir, binaryHash: hash}, nil
+}
+
+// cacheable reports whether a's findings may be cached: see the package
+// doc comment for why facts- and Requires-graph-participating analyzers
+// are excluded.
+func cacheable(a *analysis.Analyzer, dependedUpon map[*analysis.Analyzer]bool) bool {
+	return !dependedUpon[a] && len(a.FactTypes) == 0
+}
+
+// packageCacheKey hashes everything about this package and its build
+// environment that an analyzer's findings could depend on: the sorted
+// contents of its source files, the export data of its direct
+// dependencies (sorted by import path), and the resolved -config file.
+func packageCacheKey(filenames []string, deps []dep, configPath string) (string, error) {
+	h := sha256.New()
+
+	sortedFiles := append([]string(nil), filenames...)
+	sort.Strings(sortedFiles)
+	for _, name := range sortedFiles {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "src:%s:%d:", name, len(data))
+		h.Write(data)
+	}
+
+	sortedDeps := append([]dep(nil), deps...)
+	sort.Slice(sortedDeps, func(i, j int) bool { return sortedDeps[i].importPath < sortedDeps[j].importPath })
+	for _, d := range sortedDeps {
+		data, err := ioutil.ReadFile(d.archive)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "dep:%s:%d:", d.importPath, len(data))
+		h.Write(data)
+	}
+
+	if configPath != "" {
+		data, err := ioutil.ReadFile(configPath)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "config:%d:", len(data))
+		h.Write(data)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// analyzerKey stamps a, so that entries for it are namespaced separately
+// from every other analyzer the checker binary runs.
+func (c *analysisCache) analyzerKey(a *analysis.Analyzer) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s:%s:%s", c.binaryHash, a.Name, a.Doc)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// entryPath returns the file an (a, packageKey) entry lives at: a
+// content-addressed name one level of fan-out (a subdirectory named by its
+// first two hex digits) below dir, so that no
+// single directory ends up with one file per package ever analyzed.
+func (c *analysisCache) entryPath(a *analysis.Analyzer, packageKey string) string {
+	sum := sha256.Sum256([]byte(c.analyzerKey(a) + ":" + packageKey))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(c.dir, name[:2], name+".cache")
+}
+
+// lookup returns the findings a reported the last time it ran against this
+// exact packageKey, if any.
+func (c *analysisCache) lookup(a *analysis.Analyzer, packageKey string) ([]finding, bool) {
+	data, err := ioutil.ReadFile(c.entryPath(a, packageKey))
+	if err != nil {
+		return nil, false
+	}
+	var cached []cachedFinding
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&cached); err != nil {
+		return nil, false
+	}
+	findings := make([]finding, 0, len(cached))
+	for _, cf := range cached {
+		findings = append(findings, cf.toFinding(a.Name))
+	}
+	return findings, true
+}
+
+// store atomically writes fs as the cache entry for (a, packageKey).
+func (c *analysisCache) store(a *analysis.Analyzer, packageKey string, fs []finding) error {
+	path := c.entryPath(a, packageKey)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	cached := make([]cachedFinding, 0, len(fs))
+	for _, f := range fs {
+		cached = append(cached, newCachedFinding(f))
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(cached); err != nil {
+		return fmt.Errorf("encoding cache entry: %v", err)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// cachedFinding is the gob-encoded form of a finding: a's identity isn't
+// stored since entries are already namespaced by analyzerKey, and severity
+// isn't stored since it's derived from -config after a cache lookup, not a
+// property of the finding itself.
+type cachedFinding struct {
+	Pos      fsPosition
+	Message  string
+	Category string
+	Fixes    []cachedFix
+}
+
+// fsPosition mirrors token.Position's exported fields; it exists only so
+// this file doesn't need to import go/token's doc comment about it being
+// unsuitable as a map key into the gob stream.
+type fsPosition struct {
+	Filename string
+	Offset   int
+	Line     int
+	Column   int
+}
+
+type cachedFix struct {
+	Message string
+	Edits   []cachedEdit
+}
+
+type cachedEdit struct {
+	Start, End int
+	NewText    string
+}
+
+func newCachedFinding(f finding) cachedFinding {
+	cf := cachedFinding{
+		Pos:      fsPosition{Filename: f.pos.Filename, Offset: f.pos.Offset, Line: f.pos.Line, Column: f.pos.Column},
+		Message:  f.message,
+		Category: f.category,
+	}
+	for _, fix := range f.fixes {
+		cfix := cachedFix{Message: fix.message}
+		for _, e := range fix.edits {
+			cfix.Edits = append(cfix.Edits, cachedEdit{Start: e.start, End: e.end, NewText: e.newText})
+		}
+		cf.Fixes = append(cf.Fixes, cfix)
+	}
+	return cf
+}
+
+func (cf cachedFinding) toFinding(analyzer string) finding {
+	f := finding{
+		analyzer: analyzer,
+		message:  cf.Message,
+		category: cf.Category,
+	}
+	f.pos.Filename = cf.Pos.Filename
+	f.pos.Offset = cf.Pos.Offset
+	f.pos.Line = cf.Pos.Line
+	f.pos.Column = cf.Pos.Column
+	for _, fix := range cf.Fixes {
+		sf := suggestedFix{message: fix.Message}
+		for _, e := range fix.Edits {
+			sf.edits = append(sf.edits, textEdit{start: e.Start, end: e.End, newText: e.NewText})
+		}
+		f.fixes = append(f.fixes, sf)
+	}
+	return f
+}
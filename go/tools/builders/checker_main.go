@@ -13,13 +13,17 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-// Loads and runs registered analyses on a well-typed Go package.
-// The code in this file is combined with the code generated by
-// generate_checker_main.go.
-
+// Loads and runs registered golang.org/x/tools/go/analysis Analyzers on a
+// well-typed Go package, in the manner of x/tools' unitchecker: Analyzers
+// are scheduled in Requires order, their ResultOf values are threaded into
+// dependents, and any Facts they export are gob-encoded to an output file so
+// that the next package up the import graph can import them. The code in
+// this file is combined with the code generated by generate_checker_main.go,
+// which is expected to populate registeredAnalyzers via register().
 package main
 
 import (
+	"encoding/gob"
 	"errors"
 	"flag"
 	"fmt"
@@ -31,37 +35,129 @@ import (
 	"log"
 	"os"
 	"path/filepath"
-	"regexp"
 	"sort"
 	"strings"
+	"sync"
 
-	"github.com/bazelbuild/rules_go/go/tools/analysis"
+	"golang.org/x/tools/go/analysis"
 	"golang.org/x/tools/go/gcexportdata"
 )
 
-// run returns an error only if the package is successfully loaded and at least
-// one analysis fails. All other errors (e.g. during loading) are logged but
-// do not return an error so as not to unnecessarily interrupt builds.
+// registeredAnalyzers is the set of Analyzers this checker binary was built
+// with. It is populated by register, which generate_checker_main.go calls
+// for each analyzer it wires in.
+var registeredAnalyzers []*analysis.Analyzer
+
+// register adds a to the set of Analyzers the checker runs. It is called
+// from generated code, not from this file. a's FactTypes are gob.Register-ed
+// so that factSet.encodeTo can gob-encode the analysis.Fact interface values
+// a's Run exports; without this, encoding fails at run time with "gob: type
+// not registered for interface" for every fact-exporting Analyzer.
+func register(a *analysis.Analyzer) {
+	registeredAnalyzers = append(registeredAnalyzers, a)
+	for _, f := range a.FactTypes {
+		gob.Register(f)
+	}
+}
+
+// dep describes one direct dependency of the package under analysis: the
+// archive containing its export data, and, if it exported any Facts the
+// last time it was analyzed, the file those Facts were gob-encoded to.
+type dep struct {
+	importPath string
+	archive    string
+	factsFile  string
+}
+
+// loadedPackage is the parsed, type-checked form of the package under
+// analysis, in the shape golang.org/x/tools/go/analysis.Pass expects it.
+type loadedPackage struct {
+	Fset  *token.FileSet
+	Files []*ast.File
+	Types *types.Package
+	Info  *types.Info
+	// OtherFiles holds the package's non-Go source files, for analyzers
+	// (e.g. asmdecl, buildtag) that read them via pass.OtherFiles.
+	OtherFiles []string
+}
+
+// finding is a single analyzer diagnostic, resolved to a position so it can
+// be sorted and printed without holding on to the Pass that produced it.
+type finding struct {
+	analyzer string
+	severity severity
+	pos      token.Position
+	message  string
+	category string
+	fixes    []suggestedFix
+}
+
+// run returns an error only if the package is successfully loaded and at
+// least one analysis fails. All other errors (e.g. during loading) are
+// logged but do not return an error so as not to unnecessarily interrupt
+// builds. The exception is the `go vet -vettool=` protocol (-flags, -V=full,
+// -unitchecker or a bare .cfg argument), where run returns whatever error
+// the unitchecker entry points report.
 func run(args []string) error {
+	// go vet -vettool=<this binary> execs the tool with exactly "-flags" or
+	// "-V=full" before ever invoking it for real, and aborts if it doesn't
+	// get the response that protocol requires; handle those here, ahead of
+	// the checker's own flag set, which doesn't know either flag.
+	if len(args) > 0 {
+		switch {
+		case args[0] == "-flags":
+			return printUnitcheckerFlags()
+		case strings.HasPrefix(args[0], "-V="):
+			return printUnitcheckerVersion(args[0])
+		}
+	}
+
 	archiveFiles := multiFlag{}
 	flags := flag.NewFlagSet("checker", flag.ContinueOnError)
-	flags.Var(&archiveFiles, "archivefile", "Archive file of a direct dependency")
+	flags.Var(&archiveFiles, "archivefile", "Importpath, archive file, and (optional) facts file of a direct dependency, '='-separated")
 	stdlib := flags.String("stdlib", "", "Root directory of stdlib")
+	factsOut := flags.String("factsfile", "", "Path to write this package's exported facts to")
+	configPath := flags.String("config", "", "Path to the nogo YAML/JSON configuration file")
+	outputFormat := flags.String("output-format", "text", "Format for -output-file: text, json, or sarif")
+	outputFile := flags.String("output-file", "", "Path to write a machine-readable findings report to, even when the build passes")
+	cacheDir := flags.String("cache-dir", "", "Directory to cache analyzer findings in, keyed by a hash of their inputs")
+	unitcheckerConfigPath := flags.String("unitchecker", "", "Path to a unitchecker-protocol Config JSON file; if set, every other flag except -json is ignored")
+	unitcheckerJSON := flags.Bool("json", false, "With -unitchecker, emit the diagnostic JSON tree cmd/vet expects instead of plain text to stderr")
 	if err := flags.Parse(args); err != nil {
 		log.Println(err)
 		return nil
 	}
+	// `go vet -vettool=` never sets -unitchecker: cmd/go's Builder.vet runs
+	// the tool as `tool <declared vet flags> vet.cfg`, i.e. the Config path
+	// as a bare positional argument. Accept that in addition to -unitchecker
+	// so the same binary satisfies both invocations.
+	if *unitcheckerConfigPath != "" {
+		return runUnitchecker(*unitcheckerConfigPath, *unitcheckerJSON)
+	}
 	if *stdlib == "" {
+		if rest := flags.Args(); len(rest) == 1 && strings.HasSuffix(rest[0], ".cfg") {
+			return runUnitchecker(rest[0], *unitcheckerJSON)
+		}
 		log.Printf("missing stdlib root directory")
 		return nil
 	}
-	importsToArchives := make(map[string]string)
-	for _, a := range archiveFiles {
-		kv := strings.Split(a, "=")
-		if len(kv) != 2 {
-			continue // sanity check
+	cfg := newConfig()
+	if *configPath != "" {
+		c, err := loadConfig(*configPath)
+		if err != nil {
+			log.Printf("error loading -config %s: %v", *configPath, err)
+			return nil
 		}
-		importsToArchives[kv[0]] = kv[1]
+		cfg = c
+	}
+	deps, err := parseDeps(archiveFiles)
+	if err != nil {
+		log.Printf("error parsing -archivefile: %v", err)
+		return nil
+	}
+	importsToArchives := make(map[string]string, len(deps))
+	for _, d := range deps {
+		importsToArchives[d.importPath] = d.archive
 	}
 	fset := token.NewFileSet()
 	imp := &importer{
@@ -70,86 +166,84 @@ func run(args []string) error {
 		importsToArchives: importsToArchives,
 		stdlib:            *stdlib,
 	}
-	apkg, err := load(fset, imp, flags.Args())
+	apkg, err := load(fset, imp, flags.Args(), nil)
 	if err != nil {
 		log.Printf("error loading package: %v\n", err)
 		return nil
 	}
+	if apkg == nil {
+		// Type errors were already reported by load.
+		return nil
+	}
 
-	c := make(chan result)
-	// Perform analyses in parallel.
-	for _, a := range analysis.Analyses() {
-		go func(a *analysis.Analysis) {
-			defer func() {
-				// Prevent a panic in a single analysis from interrupting other analyses.
-				if r := recover(); r != nil {
-					c <- result{name: a.Name, err: fmt.Errorf("panic : %v", r)}
-				}
-			}()
-			res, err := a.Run(apkg)
-			switch err {
-			case nil:
-				c <- result{name: a.Name, findings: res.Findings}
-			case analysis.ErrSkipped:
-				c <- result{name: a.Name, err: fmt.Errorf("skipped : %v", err)}
-			default:
-				c <- result{name: a.Name, err: fmt.Errorf("internal error: %v", err)}
-			}
-		}(a)
+	factsFiles := make(map[string]string, len(deps))
+	for _, d := range deps {
+		if d.factsFile != "" {
+			factsFiles[d.importPath] = d.factsFile
+		}
 	}
-	// Collate analysis results.
-	var allFindings []*analysis.Finding
-	failBuild := false
-	for i := 0; i < len(analysis.Analyses()); i++ {
-		result := <-c
-		if result.err != nil {
-			// Analysis failed or skipped.
-			log.Printf("analysis %q %v", result.name, result.err)
-			continue
+	facts, err := importFacts(factsFiles, imp.packages)
+	if err != nil {
+		log.Printf("error importing facts: %v\n", err)
+		facts = newFactSet()
+	}
+
+	var cache *analysisCache
+	var packageKey string
+	if *cacheDir != "" {
+		cache, err = newAnalysisCache(*cacheDir)
+		if err != nil {
+			log.Printf("error opening -cache-dir %s: %v", *cacheDir, err)
+			cache = nil
+		} else if packageKey, err = packageCacheKey(flags.Args(), deps, *configPath); err != nil {
+			log.Printf("error computing cache key: %v", err)
+			cache = nil
 		}
-		if len(result.findings) == 0 {
+	}
+
+	allFindings, err := runAnalyzers(registeredAnalyzers, apkg, facts, cache, packageKey)
+	if err != nil {
+		log.Printf("error running analyzers: %v\n", err)
+		return nil
+	}
+
+	ignores := parseIgnores(apkg.Fset, apkg.Files)
+	var findings []finding
+	failBuild := false
+	for _, f := range allFindings {
+		if !cfg.includes(f) {
 			continue
 		}
-		config, ok := configs[result.name]
-		if !ok {
-			// The default behavior is not to fail builds but print analysis findings.
-			allFindings = append(allFindings, result.findings...)
+		if ignores.suppress(f) {
 			continue
 		}
-		if config.severity == severityError {
+		f.severity = cfg.severityFor(f.analyzer)
+		if f.severity == severityError {
 			failBuild = true
 		}
-		// Discard findings based on the check configuration.
-		for _, finding := range result.findings {
-			filename := fset.File(finding.Pos).Name()
-			include := true
-			if len(config.applyTo) > 0 {
-				// This analysis applies exclusively to a set of files.
-				include = false
-				for pattern := range config.applyTo {
-					if matched, err := regexp.MatchString(pattern, filename); err == nil && matched {
-						include = true
-					}
-				}
-			}
-			for pattern := range config.whitelist {
-				if matched, err := regexp.MatchString(pattern, filename); err == nil && matched {
-					include = false
-				}
-			}
-			if include {
-				allFindings = append(allFindings, finding)
-			}
+		findings = append(findings, f)
+	}
+	findings = append(findings, ignores.unused()...)
+
+	if *factsOut != "" {
+		if err := facts.encodeTo(*factsOut); err != nil {
+			log.Printf("error writing %s: %v\n", *factsOut, err)
+		}
+	}
+
+	if *outputFile != "" {
+		if err := writeReport(*outputFile, *outputFormat, findings, registeredAnalyzers); err != nil {
+			log.Printf("error writing -output-file %s: %v\n", *outputFile, err)
 		}
 	}
-	// Print analysis results, returning an error to fail the build if necessary.
-	if len(allFindings) != 0 {
-		sort.Slice(allFindings, func(i, j int) bool {
-			return allFindings[i].Pos < allFindings[j].Pos
+
+	if len(findings) != 0 {
+		sort.Slice(findings, func(i, j int) bool {
+			return findings[i].pos.Offset < findings[j].pos.Offset
 		})
 		errMsg := "errors found during build-time code analysis:\n"
-		for _, f := range allFindings {
-			errMsg += fmt.Sprintf("%s: %s\n", fset.Position(f.Pos), f.Message)
+		for _, f := range findings {
+			errMsg += fmt.Sprintf("%s: [%s] %s\n", f.pos, f.analyzer, f.message)
 		}
 		if failBuild {
 			return errors.New(errMsg)
@@ -159,17 +253,170 @@ func run(args []string) error {
 	return nil
 }
 
-type config struct {
-	severity           severity
-	applyTo, whitelist map[string]bool
+// runAnalyzers schedules analyzers in Requires order, running all analyzers
+// at the same dependency depth concurrently, and threads each analyzer's
+// result into the Pass of every analyzer that requires it. If cache is
+// non-nil, analyzers eligible for caching (see cacheable) are looked up
+// under packageKey before they run, and their findings are stored back on a
+// miss.
+func runAnalyzers(analyzers []*analysis.Analyzer, apkg *loadedPackage, facts *factSet, cache *analysisCache, packageKey string) (findings []finding, err error) {
+	levels, err := scheduleLevels(analyzers)
+	if err != nil {
+		return nil, err
+	}
+
+	dependedUpon := make(map[*analysis.Analyzer]bool, len(analyzers))
+	for _, a := range analyzers {
+		for _, req := range a.Requires {
+			dependedUpon[req] = true
+		}
+	}
+
+	results := make(map[*analysis.Analyzer]interface{})
+	var resultsMu sync.Mutex
+
+	for _, level := range levels {
+		type outcome struct {
+			a        *analysis.Analyzer
+			result   interface{}
+			findings []finding
+			err      error
+		}
+		c := make(chan outcome, len(level))
+		for _, a := range level {
+			useCache := cache != nil && cacheable(a, dependedUpon)
+			if useCache {
+				if fs, ok := cache.lookup(a, packageKey); ok {
+					c <- outcome{a: a, findings: fs}
+					continue
+				}
+			}
+			go func(a *analysis.Analyzer, useCache bool) {
+				defer func() {
+					if r := recover(); r != nil {
+						c <- outcome{a: a, err: fmt.Errorf("panic: %v", r)}
+					}
+				}()
+				resultsMu.Lock()
+				resultOf := make(map[*analysis.Analyzer]interface{}, len(a.Requires))
+				for _, req := range a.Requires {
+					resultOf[req] = results[req]
+				}
+				resultsMu.Unlock()
+
+				var fs []finding
+				pass := &analysis.Pass{
+					Analyzer:   a,
+					Fset:       apkg.Fset,
+					Files:      apkg.Files,
+					Pkg:        apkg.Types,
+					TypesInfo:  apkg.Info,
+					OtherFiles: apkg.OtherFiles,
+					ResultOf:   resultOf,
+					Report: func(d analysis.Diagnostic) {
+						fs = append(fs, finding{
+							analyzer: a.Name,
+							pos:      apkg.Fset.Position(d.Pos),
+							message:  d.Message,
+							category: d.Category,
+							fixes:    toSuggestedFixes(apkg.Fset, d.SuggestedFixes),
+						})
+					},
+					ImportObjectFact:  facts.importObjectFact,
+					ExportObjectFact:  facts.exportObjectFact,
+					ImportPackageFact: facts.importPackageFact,
+					ExportPackageFact: func(fact analysis.Fact) { facts.exportPackageFact(apkg.Types, fact) },
+					AllObjectFacts:    facts.allObjectFacts,
+					AllPackageFacts:   facts.allPackageFacts,
+				}
+				res, err := a.Run(pass)
+				if err != nil {
+					c <- outcome{a: a, err: err}
+					return
+				}
+				if useCache {
+					if err := cache.store(a, packageKey, fs); err != nil {
+						log.Printf("caching %q: %v", a.Name, err)
+					}
+				}
+				c <- outcome{a: a, result: res, findings: fs}
+			}(a, useCache)
+		}
+		for i := 0; i < len(level); i++ {
+			o := <-c
+			if o.err != nil {
+				log.Printf("analysis %q: %v", o.a.Name, o.err)
+				continue
+			}
+			resultsMu.Lock()
+			results[o.a] = o.result
+			resultsMu.Unlock()
+			findings = append(findings, o.findings...)
+		}
+	}
+	return findings, nil
 }
 
-type severity uint8
+// scheduleLevels partitions analyzers into a sequence of levels such that
+// every analyzer in level i only Requires analyzers in levels < i, and
+// analyzers within a level can therefore run concurrently.
+func scheduleLevels(analyzers []*analysis.Analyzer) ([][]*analysis.Analyzer, error) {
+	remaining := make(map[*analysis.Analyzer]bool, len(analyzers))
+	for _, a := range analyzers {
+		remaining[a] = true
+	}
+	for _, a := range analyzers {
+		for _, req := range a.Requires {
+			if !remaining[req] {
+				return nil, fmt.Errorf("analyzer %q requires %q, which is not registered", a.Name, req.Name)
+			}
+		}
+	}
+	var levels [][]*analysis.Analyzer
+	done := make(map[*analysis.Analyzer]bool, len(analyzers))
+	for len(remaining) > 0 {
+		var level []*analysis.Analyzer
+		for a := range remaining {
+			ready := true
+			for _, req := range a.Requires {
+				if !done[req] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				level = append(level, a)
+			}
+		}
+		if len(level) == 0 {
+			return nil, errors.New("cycle detected in analyzer Requires graph")
+		}
+		for _, a := range level {
+			delete(remaining, a)
+			done[a] = true
+		}
+		levels = append(levels, level)
+	}
+	return levels, nil
+}
 
-const (
-	severityWarning severity = iota
-	severityError
-)
+// parseDeps parses -archivefile values of the form
+// "importpath=archivefile" or "importpath=archivefile=factsfile".
+func parseDeps(archiveFiles []string) ([]dep, error) {
+	var deps []dep
+	for _, a := range archiveFiles {
+		parts := strings.SplitN(a, "=", 3)
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("malformed -archivefile %q", a)
+		}
+		d := dep{importPath: parts[0], archive: parts[1]}
+		if len(parts) == 3 {
+			d.factsFile = parts[2]
+		}
+		deps = append(deps, d)
+	}
+	return deps, nil
+}
 
 func main() {
 	log.SetFlags(0) // no timestamp
@@ -179,15 +426,10 @@ func main() {
 	}
 }
 
-type result struct {
-	name      string
-	findings  []*analysis.Finding
-	err       error
-	failBuild bool
-}
-
 // load parses and type checks the source code in each file in filenames.
-func load(fset *token.FileSet, imp types.Importer, filenames []string) (*analysis.Package, error) {
+// otherFiles, if non-nil, is recorded as the package's non-Go sources but
+// otherwise plays no part in loading.
+func load(fset *token.FileSet, imp types.Importer, filenames []string, otherFiles []string) (*loadedPackage, error) {
 	if len(filenames) == 0 {
 		return nil, errors.New("no filenames")
 	}
@@ -223,7 +465,7 @@ func load(fset *token.FileSet, imp types.Importer, filenames []string) (*analysi
 		// Errors were already reported through config.Error.
 		return nil, nil
 	}
-	return &analysis.Package{Fset: fset, Files: files, Types: pkg, Info: info}, nil
+	return &loadedPackage{Fset: fset, Files: files, Types: pkg, Info: info, OtherFiles: otherFiles}, nil
 }
 
 type importer struct {
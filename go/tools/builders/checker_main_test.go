@@ -0,0 +1,74 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/analysis"
+)
+
+// TestScheduleLevelsMissingRequires guards against the regression where an
+// Analyzer whose Requires names an Analyzer that was never passed to
+// register (e.g. a one-line mistake in generated registration code)
+// produced the same "cycle detected" error as a genuine cycle, sending
+// whoever wires up generate_checker_main.go down the wrong debugging path.
+func TestScheduleLevelsMissingRequires(t *testing.T) {
+	missing := &analysis.Analyzer{Name: "missing"}
+	a := &analysis.Analyzer{Name: "a", Requires: []*analysis.Analyzer{missing}}
+
+	_, err := scheduleLevels([]*analysis.Analyzer{a})
+	if err == nil {
+		t.Fatal("scheduleLevels with an unregistered Requires = nil error, want one naming the missing analyzer")
+	}
+	if !strings.Contains(err.Error(), `"a" requires "missing", which is not registered`) {
+		t.Errorf("scheduleLevels error = %q, want it to name the missing analyzer rather than report a cycle", err)
+	}
+}
+
+// TestScheduleLevelsCycle guards the genuine-cycle case: with every
+// Requires resolvable to a registered Analyzer, a real cycle must still be
+// reported as one.
+func TestScheduleLevelsCycle(t *testing.T) {
+	a := &analysis.Analyzer{Name: "a"}
+	b := &analysis.Analyzer{Name: "b"}
+	a.Requires = []*analysis.Analyzer{b}
+	b.Requires = []*analysis.Analyzer{a}
+
+	_, err := scheduleLevels([]*analysis.Analyzer{a, b})
+	if err == nil {
+		t.Fatal("scheduleLevels with a genuine cycle = nil error, want one")
+	}
+	if !strings.Contains(err.Error(), "cycle detected") {
+		t.Errorf("scheduleLevels error = %q, want it to report a cycle", err)
+	}
+}
+
+// TestScheduleLevelsOrdersByRequires checks the non-error path still
+// schedules analyzers into levels honoring Requires order.
+func TestScheduleLevelsOrdersByRequires(t *testing.T) {
+	base := &analysis.Analyzer{Name: "base"}
+	dependent := &analysis.Analyzer{Name: "dependent", Requires: []*analysis.Analyzer{base}}
+
+	levels, err := scheduleLevels([]*analysis.Analyzer{dependent, base})
+	if err != nil {
+		t.Fatalf("scheduleLevels: %v", err)
+	}
+	if len(levels) != 2 || len(levels[0]) != 1 || levels[0][0] != base || len(levels[1]) != 1 || levels[1][0] != dependent {
+		t.Errorf("levels = %+v, want [[base] [dependent]]", levels)
+	}
+}
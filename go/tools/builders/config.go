@@ -0,0 +1,207 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// config.go loads the nogo YAML/JSON configuration passed via -config and
+// decides, for each finding, whether it should be reported and whether it
+// should fail the build. Source files are classified into named groups by
+// regex, and analyzers are enabled or disabled per group, so that e.g.
+// vendored or generated trees can be exempted from checks that apply to
+// first-party code.
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+
+	"gopkg.in/yaml.v2"
+)
+
+type severity uint8
+
+const (
+	severityWarning severity = iota
+	severityError
+)
+
+// The values analyzers.<name>.filter accepts. Each expands to an override
+// enabling the group of the same name, which must be declared under groups:.
+const (
+	filterInternal  = "internal"
+	filterExternal  = "external"
+	filterGenerated = "generated"
+)
+
+// rawConfig is the YAML/JSON shape of a -config file.
+type rawConfig struct {
+	Groups    []rawGroup             `yaml:"groups" json:"groups"`
+	Analyzers map[string]rawAnalyzer `yaml:"analyzers" json:"analyzers"`
+}
+
+type rawGroup struct {
+	Name    string `yaml:"name" json:"name"`
+	Regex   string `yaml:"regex" json:"regex"`
+	Default bool   `yaml:"default" json:"default"`
+}
+
+type rawAnalyzer struct {
+	Default   bool            `yaml:"default" json:"default"`
+	Overrides map[string]bool `yaml:"overrides" json:"overrides"`
+	Exclude   []string        `yaml:"exclude" json:"exclude"`
+	Severity  string          `yaml:"severity" json:"severity"`
+	Filter    string          `yaml:"filter" json:"filter"`
+}
+
+type group struct {
+	name string
+	re   *regexp.Regexp
+}
+
+type analyzerConfig struct {
+	defaultEnabled bool
+	overrides      map[string]bool
+	exclude        []*regexp.Regexp
+	severity       severity
+}
+
+// config is the compiled, ready-to-query form of a -config file.
+type config struct {
+	groups       []group
+	defaultGroup string
+	analyzers    map[string]analyzerConfig
+}
+
+// newConfig returns the config used when no -config flag is given: every
+// analyzer runs at warning severity on every file.
+func newConfig() *config {
+	return &config{analyzers: make(map[string]analyzerConfig)}
+}
+
+func loadConfig(path string) (*config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw rawConfig
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+
+	cfg := &config{analyzers: make(map[string]analyzerConfig, len(raw.Analyzers))}
+	for _, g := range raw.Groups {
+		re, err := regexp.Compile(g.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("group %q: invalid regex %q: %v", g.Name, g.Regex, err)
+		}
+		cfg.groups = append(cfg.groups, group{name: g.Name, re: re})
+		if g.Default {
+			cfg.defaultGroup = g.Name
+		}
+	}
+
+	for name, ra := range raw.Analyzers {
+		ac := analyzerConfig{
+			defaultEnabled: ra.Default,
+			overrides:      ra.Overrides,
+		}
+		switch ra.Severity {
+		case "", "warning":
+			ac.severity = severityWarning
+		case "error":
+			ac.severity = severityError
+		default:
+			return nil, fmt.Errorf("analyzer %q: unknown severity %q", name, ra.Severity)
+		}
+		if ra.Filter != "" {
+			switch ra.Filter {
+			case filterInternal, filterExternal, filterGenerated:
+			default:
+				return nil, fmt.Errorf("analyzer %q: unknown filter %q", name, ra.Filter)
+			}
+			if !cfg.hasGroup(ra.Filter) {
+				return nil, fmt.Errorf("analyzer %q: filter %q has no matching group in groups:", name, ra.Filter)
+			}
+			if _, explicit := ra.Overrides[ra.Filter]; explicit {
+				return nil, fmt.Errorf("analyzer %q: filter %q conflicts with an explicit overrides entry for group %q; set one or the other, not both", name, ra.Filter, ra.Filter)
+			}
+			ac.defaultEnabled = false
+			if ac.overrides == nil {
+				ac.overrides = make(map[string]bool)
+			}
+			ac.overrides[ra.Filter] = true
+		}
+		for _, pattern := range ra.Exclude {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("analyzer %q: invalid exclude regex %q: %v", name, pattern, err)
+			}
+			ac.exclude = append(ac.exclude, re)
+		}
+		cfg.analyzers[name] = ac
+	}
+	return cfg, nil
+}
+
+// hasGroup reports whether name was declared in the config's groups: list.
+func (c *config) hasGroup(name string) bool {
+	for _, g := range c.groups {
+		if g.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// groupFor resolves filename to a group name: the last group in the config
+// whose regex matches, or the group marked default if none match.
+func (c *config) groupFor(filename string) string {
+	result := c.defaultGroup
+	for _, g := range c.groups {
+		if g.re.MatchString(filename) {
+			result = g.name
+		}
+	}
+	return result
+}
+
+// includes reports whether f should be reported at all, after resolving its
+// file to a group, applying the analyzer's default/overrides for that
+// group, and checking the analyzer's exclude patterns against the message.
+func (c *config) includes(f finding) bool {
+	ac, ok := c.analyzers[f.analyzer]
+	if !ok {
+		// No configuration for this analyzer: report everything.
+		return true
+	}
+	enabled := ac.defaultEnabled
+	if v, ok := ac.overrides[c.groupFor(f.pos.Filename)]; ok {
+		enabled = v
+	}
+	if !enabled {
+		return false
+	}
+	for _, re := range ac.exclude {
+		if re.MatchString(f.message) {
+			return false
+		}
+	}
+	return true
+}
+
+// severityFor returns the severity configured for analyzer, or
+// severityWarning if it isn't mentioned in the config.
+func (c *config) severityFor(analyzer string) severity {
+	return c.analyzers[analyzer].severity
+}
@@ -0,0 +1,82 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"go/token"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "nogo.yaml")
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestLoadConfigFilterConflictsWithOverride guards against filter sugar
+// silently clobbering an explicit overrides entry for the same group: a
+// user who writes both "overrides: {internal: false}" and "filter:
+// internal" for an analyzer gets an error naming the conflict, not a
+// config that quietly ignores their explicit false.
+func TestLoadConfigFilterConflictsWithOverride(t *testing.T) {
+	path := writeConfig(t, `
+groups:
+  - name: internal
+    regex: .*
+    default: true
+analyzers:
+  printf:
+    default: false
+    filter: internal
+    overrides:
+      internal: false
+`)
+	_, err := loadConfig(path)
+	if err == nil {
+		t.Fatal("loadConfig with filter and an explicit override for the same group = nil error, want a conflict error")
+	}
+	if !strings.Contains(err.Error(), "conflicts with an explicit overrides entry") {
+		t.Errorf("loadConfig error = %q, want it to name the filter/overrides conflict", err)
+	}
+}
+
+// TestLoadConfigFilterSugar checks the non-conflicting case still expands
+// filter into the expected override.
+func TestLoadConfigFilterSugar(t *testing.T) {
+	path := writeConfig(t, `
+groups:
+  - name: internal
+    regex: .*
+    default: true
+analyzers:
+  printf:
+    default: false
+    filter: internal
+`)
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if !cfg.includes(finding{analyzer: "printf", pos: token.Position{Filename: "p.go"}}) {
+		t.Error("finding in the internal group should be included after filter: internal expands to overrides[internal]=true")
+	}
+}
@@ -0,0 +1,188 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// facts.go lets Analyzers that declare a FactTypes (e.g. nilness, printf)
+// propagate information across the import graph: facts an Analyzer exports
+// while analyzing a package are gob-encoded to that package's -factsfile,
+// and decoded back into an analysis.Pass when a downstream package is
+// analyzed. Objects cannot be gob-encoded directly, so each fact is keyed by
+// its golang.org/x/tools/go/types/objectpath, which is stable across the
+// separate loads of the same package that reading archives in each Bazel
+// action entails.
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"reflect"
+
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/types/objectpath"
+)
+
+// gobFact is the wire representation of a single Fact exported by some
+// Analyzer. Object is empty for a package-level fact.
+type gobFact struct {
+	PkgPath string
+	Object  objectpath.Path
+	Fact    analysis.Fact
+}
+
+// factKey identifies a decoded fact in memory: the object it is attached to
+// (nil for a package fact), and the concrete type of the fact, since a
+// single object may carry facts from more than one Analyzer.
+type factKey struct {
+	obj interface{} // types.Object, or nil for a package fact
+	pkg *types.Package
+	typ string
+}
+
+// factSet holds every Fact decoded from dependencies' -factsfile outputs,
+// plus any this package's Analyzers go on to export, keyed so that
+// analysis.Pass's Import*Fact/Export*Fact/All*Facts callbacks can be
+// implemented directly against it.
+type factSet struct {
+	m        map[factKey]analysis.Fact
+	exported []gobFact
+}
+
+func newFactSet() *factSet {
+	return &factSet{m: make(map[factKey]analysis.Fact)}
+}
+
+// importFacts decodes the facts file of every import path in factsFiles
+// that has one, resolving each fact's object via packages, the set of
+// types.Package values an importer has already loaded. factsFiles and
+// packages are both keyed by import path so this works the same whether
+// those packages came from the -archivefile importer or the unitchecker
+// ImportMap/PackageFile one.
+func importFacts(factsFiles map[string]string, packages map[string]*types.Package) (*factSet, error) {
+	facts := newFactSet()
+	for _, factsFile := range factsFiles {
+		if factsFile == "" {
+			continue
+		}
+		data, err := ioutil.ReadFile(factsFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %v", factsFile, err)
+		}
+		var gobFacts []gobFact
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&gobFacts); err != nil {
+			return nil, fmt.Errorf("decoding %s: %v", factsFile, err)
+		}
+		for _, gf := range gobFacts {
+			pkg, ok := packages[gf.PkgPath]
+			if !ok {
+				// The declaring package was never imported by this package, so
+				// nothing here can reference its objects; skip it.
+				continue
+			}
+			key := factKey{pkg: pkg, typ: fmt.Sprintf("%T", gf.Fact)}
+			if gf.Object != "" {
+				obj, err := objectpath.Object(pkg, gf.Object)
+				if err != nil {
+					// The object no longer exists in this build (e.g. it was
+					// unexported and removed); its fact can no longer apply.
+					continue
+				}
+				key.obj = obj
+			}
+			facts.m[key] = gf.Fact
+		}
+	}
+	return facts, nil
+}
+
+// encodeTo gob-encodes every fact this package's Analyzers exported and
+// writes it to path, so that packages which import this one can later
+// import them back via importFacts.
+func (fs *factSet) encodeTo(path string) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(fs.exported); err != nil {
+		return fmt.Errorf("encoding facts: %v", err)
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), os.FileMode(0644))
+}
+
+func (fs *factSet) importObjectFact(obj types.Object, fact analysis.Fact) bool {
+	v, ok := fs.m[factKey{obj: obj, pkg: obj.Pkg(), typ: fmt.Sprintf("%T", fact)}]
+	if !ok {
+		return false
+	}
+	copyFact(fact, v)
+	return true
+}
+
+func (fs *factSet) exportObjectFact(obj types.Object, fact analysis.Fact) {
+	key := factKey{obj: obj, pkg: obj.Pkg(), typ: fmt.Sprintf("%T", fact)}
+	fs.m[key] = fact
+	path, err := objectpath.For(obj)
+	if err != nil {
+		// Facts on objects that can't be named relative to their package (e.g.
+		// local variables) can't be exported across packages; they're still
+		// visible to later analyzers in this run via fs.m.
+		return
+	}
+	fs.exported = append(fs.exported, gobFact{PkgPath: obj.Pkg().Path(), Object: path, Fact: fact})
+}
+
+func (fs *factSet) importPackageFact(pkg *types.Package, fact analysis.Fact) bool {
+	v, ok := fs.m[factKey{pkg: pkg, typ: fmt.Sprintf("%T", fact)}]
+	if !ok {
+		return false
+	}
+	copyFact(fact, v)
+	return true
+}
+
+// exportPackageFact records fact against pkg, the package currently under
+// analysis. analysis.Pass.ExportPackageFact takes no package argument
+// because it is always the one being analyzed, so callers bind pkg via a
+// closure over factSet.exportPackageFact when constructing a Pass.
+func (fs *factSet) exportPackageFact(pkg *types.Package, fact analysis.Fact) {
+	fs.m[factKey{pkg: pkg, typ: fmt.Sprintf("%T", fact)}] = fact
+	fs.exported = append(fs.exported, gobFact{PkgPath: pkg.Path(), Fact: fact})
+}
+
+func (fs *factSet) allObjectFacts() []analysis.ObjectFact {
+	var out []analysis.ObjectFact
+	for k, f := range fs.m {
+		if obj, ok := k.obj.(types.Object); ok {
+			out = append(out, analysis.ObjectFact{Object: obj, Fact: f})
+		}
+	}
+	return out
+}
+
+func (fs *factSet) allPackageFacts() []analysis.PackageFact {
+	var out []analysis.PackageFact
+	for k, f := range fs.m {
+		if k.obj == nil {
+			out = append(out, analysis.PackageFact{Package: k.pkg, Fact: f})
+		}
+	}
+	return out
+}
+
+// copyFact copies the concrete value held by src into the pointer dst,
+// which the ImportObjectFact/ImportPackageFact contract guarantees is a
+// pointer of the same type as src.
+func copyFact(dst, src analysis.Fact) {
+	reflect.ValueOf(dst).Elem().Set(reflect.ValueOf(src).Elem())
+}
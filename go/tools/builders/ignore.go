@@ -0,0 +1,175 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// ignore.go implements in-source suppression of findings, similar to
+// staticcheck's `//lint:ignore`, so a finding can be silenced without
+// touching the -config file:
+//
+//	//nogo:ignore CheckA,CheckB reason text
+//
+// silences CheckA and CheckB for the statement on the following line, and
+//
+//	//nogo:file-ignore CheckA reason text
+//
+// silences CheckA for the whole file, provided it appears in the file's
+// leading comment group. A reason is mandatory; omitting one is a
+// build-time warning. Ignores that never matched a finding are reported
+// back as a "nogo-unused-ignore" finding so they can be cleaned up.
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var (
+	lineIgnoreRe = regexp.MustCompile(`^nogo:ignore\s+(\S+)(?:\s+(.*))?$`)
+	fileIgnoreRe = regexp.MustCompile(`^nogo:file-ignore\s+(\S+)(?:\s+(.*))?$`)
+)
+
+// lineIgnore silences checks on the single line following the comment that
+// declared it.
+type lineIgnore struct {
+	file   string
+	line   int
+	checks []string
+	reason string
+	pos    token.Position
+	used   bool
+}
+
+// fileIgnore silences checks for every finding in a file.
+type fileIgnore struct {
+	file   string
+	checks []string
+	reason string
+	pos    token.Position
+	used   bool
+}
+
+// ignoreSet holds every suppression directive found while parsing a
+// package's source files.
+type ignoreSet struct {
+	lines []*lineIgnore
+	files []*fileIgnore
+}
+
+// parseIgnores scans the comments of files for //nogo:ignore and
+// //nogo:file-ignore directives.
+func parseIgnores(fset *token.FileSet, files []*ast.File) *ignoreSet {
+	is := &ignoreSet{}
+	for _, f := range files {
+		filename := fset.Position(f.Package).Filename
+		for _, cg := range f.Comments {
+			leading := cg.End() < f.Package
+			for _, c := range cg.List {
+				text := strings.TrimPrefix(strings.TrimPrefix(c.Text, "//"), " ")
+				if m := lineIgnoreRe.FindStringSubmatch(text); m != nil {
+					pos := fset.Position(c.Pos())
+					checks, reason := m[1], m[2]
+					if reason == "" {
+						log.Printf("%s: //nogo:ignore %s is missing a reason", pos, checks)
+					}
+					is.lines = append(is.lines, &lineIgnore{
+						file:   filename,
+						line:   pos.Line + 1,
+						checks: strings.Split(checks, ","),
+						reason: reason,
+						pos:    pos,
+					})
+					continue
+				}
+				if m := fileIgnoreRe.FindStringSubmatch(text); m != nil {
+					pos := fset.Position(c.Pos())
+					if !leading {
+						log.Printf("%s: //nogo:file-ignore only takes effect in the file's leading comment", pos)
+						continue
+					}
+					checks, reason := m[1], m[2]
+					if reason == "" {
+						log.Printf("%s: //nogo:file-ignore %s is missing a reason", pos, checks)
+					}
+					is.files = append(is.files, &fileIgnore{
+						file:   filename,
+						checks: strings.Split(checks, ","),
+						reason: reason,
+						pos:    pos,
+					})
+				}
+			}
+		}
+	}
+	return is
+}
+
+// suppress reports whether f matches an active ignore, marking that ignore
+// as used.
+func (is *ignoreSet) suppress(f finding) bool {
+	suppressed := false
+	for _, li := range is.lines {
+		if li.file == f.pos.Filename && li.line == f.pos.Line && matchesCheck(li.checks, f.analyzer) {
+			li.used = true
+			suppressed = true
+		}
+	}
+	for _, fi := range is.files {
+		if fi.file == f.pos.Filename && matchesCheck(fi.checks, f.analyzer) {
+			fi.used = true
+			suppressed = true
+		}
+	}
+	return suppressed
+}
+
+// unused returns a nogo-unused-ignore finding for every directive that
+// never suppressed anything, so stale suppressions get cleaned up.
+func (is *ignoreSet) unused() []finding {
+	var out []finding
+	for _, li := range is.lines {
+		if !li.used {
+			out = append(out, unusedIgnoreFinding(li.pos, li.checks, li.reason))
+		}
+	}
+	for _, fi := range is.files {
+		if !fi.used {
+			out = append(out, unusedIgnoreFinding(fi.pos, fi.checks, fi.reason))
+		}
+	}
+	return out
+}
+
+func unusedIgnoreFinding(pos token.Position, checks []string, reason string) finding {
+	return finding{
+		analyzer: "nogo-unused-ignore",
+		pos:      pos,
+		message:  fmt.Sprintf("suppression of %s (%q) never matched a finding", strings.Join(checks, ","), reason),
+	}
+}
+
+// matchesCheck reports whether name path.Matches any of the glob patterns
+// in checks.
+func matchesCheck(checks []string, name string) bool {
+	for _, check := range checks {
+		if ok, err := path.Match(strings.TrimSpace(check), name); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
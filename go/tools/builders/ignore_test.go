@@ -0,0 +1,159 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"testing"
+)
+
+// parseIgnoreTestFile parses src (with a leading package comment and a
+// //nogo:ignore-decorated statement) the way load parses a real package,
+// so parseIgnores sees the same *ast.File shape it would in production.
+func parseIgnoreTestFile(t *testing.T, src string) (*token.FileSet, *ast.File) {
+	t.Helper()
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "p.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("ParseFile: %v", err)
+	}
+	return fset, f
+}
+
+// TestParseIgnoresLineIgnoreSuppresses checks the common case: a
+// //nogo:ignore comment suppresses a finding on the following line from the
+// analyzer it names, by glob, and leaves others unsuppressed.
+func TestParseIgnoresLineIgnoreSuppresses(t *testing.T) {
+	fset, f := parseIgnoreTestFile(t, `package p
+
+func F() {
+	//nogo:ignore printf,nilness reason text
+	x := 1
+	_ = x
+}
+`)
+	is := parseIgnores(fset, []*ast.File{f})
+	if len(is.lines) != 1 {
+		t.Fatalf("len(is.lines) = %d, want 1", len(is.lines))
+	}
+
+	line := is.lines[0].line
+	suppressedPos := token.Position{Filename: "p.go", Line: line}
+	if !is.suppress(finding{analyzer: "printf", pos: suppressedPos}) {
+		t.Error("finding for a named check on the ignored line should be suppressed")
+	}
+	if is.suppress(finding{analyzer: "unrelated", pos: suppressedPos}) {
+		t.Error("finding for a check not named in the ignore should not be suppressed")
+	}
+	if is.suppress(finding{analyzer: "printf", pos: token.Position{Filename: "p.go", Line: line + 1}}) {
+		t.Error("finding on a different line should not be suppressed")
+	}
+}
+
+// TestParseIgnoresFileIgnoreRequiresLeadingComment checks that
+// //nogo:file-ignore only takes effect from the file's leading comment
+// group, matching the doc comment's stated rule.
+func TestParseIgnoresFileIgnoreRequiresLeadingComment(t *testing.T) {
+	fset, f := parseIgnoreTestFile(t, `// Package p does a thing.
+//nogo:file-ignore printf reason text
+package p
+
+func F() {
+	//nogo:file-ignore nilness not leading, should be ignored
+	x := 1
+	_ = x
+}
+`)
+	is := parseIgnores(fset, []*ast.File{f})
+	if len(is.files) != 1 {
+		t.Fatalf("len(is.files) = %d, want 1 (only the leading-comment directive should register)", len(is.files))
+	}
+	if is.files[0].checks[0] != "printf" {
+		t.Errorf("is.files[0].checks = %v, want [printf]", is.files[0].checks)
+	}
+
+	if !is.suppress(finding{analyzer: "printf", pos: token.Position{Filename: "p.go", Line: 100}}) {
+		t.Error("file-ignore should suppress a matching finding anywhere in the file")
+	}
+}
+
+// TestIgnoreSetUnusedSkipsMatchedDirectives checks that a suppression which
+// did match a finding is not surfaced as a nogo-unused-ignore finding; the
+// never-matched case is covered by TestIgnoreSetUnusedIgnore.
+func TestIgnoreSetUnusedSkipsMatchedDirectives(t *testing.T) {
+	fset, f := parseIgnoreTestFile(t, `package p
+
+func F() {
+	//nogo:ignore printf reason text
+	x := 1
+	_ = x
+}
+`)
+	is := parseIgnores(fset, []*ast.File{f})
+	line := is.lines[0].line
+
+	is.suppress(finding{analyzer: "printf", pos: token.Position{Filename: "p.go", Line: line}})
+
+	unused := is.unused()
+	if len(unused) != 0 {
+		t.Errorf("unused() = %+v, want none: the only directive matched a finding", unused)
+	}
+}
+
+// TestIgnoreSetUnusedIgnore checks the unused-ignore path directly,
+// without first calling suppress.
+func TestIgnoreSetUnusedIgnore(t *testing.T) {
+	fset, f := parseIgnoreTestFile(t, `package p
+
+func F() {
+	//nogo:ignore printf reason text
+	x := 1
+	_ = x
+}
+`)
+	is := parseIgnores(fset, []*ast.File{f})
+
+	unused := is.unused()
+	if len(unused) != 1 {
+		t.Fatalf("unused() = %+v, want 1 finding for the never-matched ignore", unused)
+	}
+	if unused[0].analyzer != "nogo-unused-ignore" {
+		t.Errorf("unused()[0].analyzer = %q, want nogo-unused-ignore", unused[0].analyzer)
+	}
+}
+
+// TestMatchesCheck checks the glob semantics matchesCheck gives //nogo
+// directives' check lists.
+func TestMatchesCheck(t *testing.T) {
+	tests := []struct {
+		checks []string
+		name   string
+		want   bool
+	}{
+		{[]string{"printf"}, "printf", true},
+		{[]string{"printf", "nilness"}, "nilness", true},
+		{[]string{"printf"}, "nilness", false},
+		{[]string{"print*"}, "printf", true},
+		{[]string{" printf "}, "printf", true},
+	}
+	for _, tt := range tests {
+		if got := matchesCheck(tt.checks, tt.name); got != tt.want {
+			t.Errorf("matchesCheck(%v, %q) = %v, want %v", tt.checks, tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// report.go renders findings to -output-file in the format -output-format
+// asks for, so that CI can ingest analyzer output independent of whether
+// the build itself passed or failed.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"io/ioutil"
+	"strings"
+
+	"golang.org/x/tools/go/analysis"
+)
+
+// suggestedFix is the Pass-independent form of an analysis.SuggestedFix,
+// with edits resolved to byte offsets rather than token.Pos. Its fields are
+// unexported; cachedFix and jsonFix are its gob and JSON encodings.
+type suggestedFix struct {
+	message string
+	edits   []textEdit
+}
+
+type textEdit struct {
+	start, end int
+	newText    string
+}
+
+func toSuggestedFixes(fset *token.FileSet, fixes []analysis.SuggestedFix) []suggestedFix {
+	var out []suggestedFix
+	for _, fix := range fixes {
+		sf := suggestedFix{message: fix.Message}
+		for _, e := range fix.TextEdits {
+			sf.edits = append(sf.edits, textEdit{
+				start:   fset.Position(e.Pos).Offset,
+				end:     fset.Position(e.End).Offset,
+				newText: string(e.NewText),
+			})
+		}
+		out = append(out, sf)
+	}
+	return out
+}
+
+// writeReport renders findings as format and writes them to path.
+func writeReport(path, format string, findings []finding, analyzers []*analysis.Analyzer) error {
+	var data []byte
+	var err error
+	switch format {
+	case "", "text":
+		data = []byte(formatText(findings))
+	case "json":
+		data, err = json.MarshalIndent(toJSONFindings(findings), "", "  ")
+	case "sarif":
+		data, err = json.MarshalIndent(toSARIF(findings, analyzers), "", "  ")
+	default:
+		return fmt.Errorf("unknown -output-format %q, want text, json, or sarif", format)
+	}
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+func formatText(findings []finding) string {
+	var b strings.Builder
+	for _, f := range findings {
+		fmt.Fprintf(&b, "%s: [%s] %s\n", f.pos, f.analyzer, f.message)
+	}
+	return b.String()
+}
+
+// jsonFinding is the -output-format=json representation of a finding.
+type jsonFinding struct {
+	Analyzer       string    `json:"analyzer"`
+	Severity       string    `json:"severity"`
+	Message        string    `json:"message"`
+	Pos            jsonPos   `json:"pos"`
+	SuggestedFixes []jsonFix `json:"suggestedFixes,omitempty"`
+}
+
+type jsonPos struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+	Col  int    `json:"col"`
+}
+
+type jsonFix struct {
+	Message string     `json:"message"`
+	Edits   []jsonEdit `json:"edits"`
+}
+
+type jsonEdit struct {
+	Start   int    `json:"start"`
+	End     int    `json:"end"`
+	NewText string `json:"newText"`
+}
+
+func toJSONFindings(findings []finding) []jsonFinding {
+	out := make([]jsonFinding, 0, len(findings))
+	for _, f := range findings {
+		jf := jsonFinding{
+			Analyzer: f.analyzer,
+			Severity: severityString(f.severity),
+			Message:  f.message,
+			Pos:      jsonPos{File: f.pos.Filename, Line: f.pos.Line, Col: f.pos.Column},
+		}
+		for _, fix := range f.fixes {
+			jfix := jsonFix{Message: fix.message}
+			for _, e := range fix.edits {
+				jfix.Edits = append(jfix.Edits, jsonEdit{Start: e.start, End: e.end, NewText: e.newText})
+			}
+			jf.SuggestedFixes = append(jf.SuggestedFixes, jfix)
+		}
+		out = append(out, jf)
+	}
+	return out
+}
+
+func severityString(s severity) string {
+	if s == severityError {
+		return "error"
+	}
+	return "warning"
+}
+
+// SARIF 2.1.0 (a subset sufficient for code-scanning ingestion).
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name  string      `json:"name"`
+	Rules []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn"`
+}
+
+func toSARIF(findings []finding, analyzers []*analysis.Analyzer) sarifLog {
+	rules := make([]sarifRule, 0, len(analyzers))
+	for _, a := range analyzers {
+		rules = append(rules, sarifRule{ID: a.Name, ShortDescription: sarifMessage{Text: a.Doc}})
+	}
+	results := make([]sarifResult, 0, len(findings))
+	for _, f := range findings {
+		level := "warning"
+		if f.severity == severityError {
+			level = "error"
+		}
+		results = append(results, sarifResult{
+			RuleID:  f.analyzer,
+			Level:   level,
+			Message: sarifMessage{Text: f.message},
+			Locations: []sarifLocation{{
+				PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: sarifArtifactLocation{URI: f.pos.Filename},
+					Region:           sarifRegion{StartLine: f.pos.Line, StartColumn: f.pos.Column},
+				},
+			}},
+		})
+	}
+	return sarifLog{
+		Schema:  "https://raw.githubusercontent.com/oasis-tcs/sarif-spec/master/Schemata/sarif-schema-2.1.0.json",
+		Version: "2.1.0",
+		Runs: []sarifRun{{
+			Tool:    sarifTool{Driver: sarifDriver{Name: "nogo", Rules: rules}},
+			Results: results,
+		}},
+	}
+}
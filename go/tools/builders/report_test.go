@@ -0,0 +1,150 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"go/token"
+	"testing"
+
+	"golang.org/x/tools/go/analysis"
+)
+
+func testFindingWithFix() finding {
+	return finding{
+		analyzer: "printf",
+		severity: severityError,
+		pos:      token.Position{Filename: "p.go", Line: 3, Column: 2},
+		message:  "bad format verb",
+		category: "printf",
+		fixes: []suggestedFix{{
+			message: "fix format verb",
+			edits:   []textEdit{{start: 10, end: 12, newText: "%s"}},
+		}},
+	}
+}
+
+// TestToJSONFindingsRoundTrip checks that a finding with a suggested fix
+// comes out of toJSONFindings with the analyzer/severity/message/pos shape
+// -output-format=json documents, including the byte-offset edits.
+func TestToJSONFindingsRoundTrip(t *testing.T) {
+	f := testFindingWithFix()
+	jfs := toJSONFindings([]finding{f})
+	if len(jfs) != 1 {
+		t.Fatalf("toJSONFindings returned %d findings, want 1", len(jfs))
+	}
+	jf := jfs[0]
+	if jf.Analyzer != f.analyzer || jf.Severity != "error" || jf.Message != f.message {
+		t.Errorf("toJSONFindings = %+v, want analyzer=%q severity=error message=%q", jf, f.analyzer, f.message)
+	}
+	if jf.Pos.File != f.pos.Filename || jf.Pos.Line != f.pos.Line || jf.Pos.Col != f.pos.Column {
+		t.Errorf("toJSONFindings pos = %+v, want %+v", jf.Pos, f.pos)
+	}
+	if len(jf.SuggestedFixes) != 1 || len(jf.SuggestedFixes[0].Edits) != 1 {
+		t.Fatalf("toJSONFindings fixes = %+v, want one fix with one edit", jf.SuggestedFixes)
+	}
+	edit := jf.SuggestedFixes[0].Edits[0]
+	if edit.Start != 10 || edit.End != 12 || edit.NewText != "%s" {
+		t.Errorf("toJSONFindings edit = %+v, want {Start:10 End:12 NewText:%%s}", edit)
+	}
+
+	// The marshaled JSON must actually scan back into the same shape CI
+	// tooling would unmarshal it as.
+	data, err := json.Marshal(jfs)
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	var roundTripped []jsonFinding
+	if err := json.Unmarshal(data, &roundTripped); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	if len(roundTripped) != 1 || roundTripped[0].Message != f.message {
+		t.Errorf("round-tripped JSON = %+v, want one finding with message %q", roundTripped, f.message)
+	}
+}
+
+// TestSeverityString checks the warning/error mapping -output-format=json
+// and sarif both rely on.
+func TestSeverityString(t *testing.T) {
+	if got := severityString(severityWarning); got != "warning" {
+		t.Errorf("severityString(severityWarning) = %q, want warning", got)
+	}
+	if got := severityString(severityError); got != "error" {
+		t.Errorf("severityString(severityError) = %q, want error", got)
+	}
+}
+
+// TestToSARIF checks the SARIF 2.1.0 shape code-scanning dashboards
+// expect: one rule per registered analyzer (keyed by name, described by
+// Doc) and one result per finding, referencing its rule and carrying a
+// physicalLocation region.
+func TestToSARIF(t *testing.T) {
+	analyzers := []*analysis.Analyzer{
+		{Name: "printf", Doc: "check printf-family calls"},
+	}
+	f := testFindingWithFix()
+
+	log := toSARIF([]finding{f}, analyzers)
+	if log.Version != "2.1.0" {
+		t.Errorf("log.Version = %q, want 2.1.0", log.Version)
+	}
+	if len(log.Runs) != 1 {
+		t.Fatalf("len(log.Runs) = %d, want 1", len(log.Runs))
+	}
+	run := log.Runs[0]
+
+	if len(run.Tool.Driver.Rules) != 1 {
+		t.Fatalf("len(rules) = %d, want 1", len(run.Tool.Driver.Rules))
+	}
+	rule := run.Tool.Driver.Rules[0]
+	if rule.ID != "printf" || rule.ShortDescription.Text != "check printf-family calls" {
+		t.Errorf("rule = %+v, want ID=printf ShortDescription.Text=%q", rule, "check printf-family calls")
+	}
+
+	if len(run.Results) != 1 {
+		t.Fatalf("len(results) = %d, want 1", len(run.Results))
+	}
+	result := run.Results[0]
+	if result.RuleID != "printf" || result.Level != "error" || result.Message.Text != f.message {
+		t.Errorf("result = %+v, want RuleID=printf Level=error Message.Text=%q", result, f.message)
+	}
+	if len(result.Locations) != 1 {
+		t.Fatalf("len(locations) = %d, want 1", len(result.Locations))
+	}
+	region := result.Locations[0].PhysicalLocation.Region
+	if result.Locations[0].PhysicalLocation.ArtifactLocation.URI != f.pos.Filename {
+		t.Errorf("artifact URI = %q, want %q", result.Locations[0].PhysicalLocation.ArtifactLocation.URI, f.pos.Filename)
+	}
+	if region.StartLine != f.pos.Line || region.StartColumn != f.pos.Column {
+		t.Errorf("region = %+v, want StartLine=%d StartColumn=%d", region, f.pos.Line, f.pos.Column)
+	}
+
+	// The whole log must marshal to valid JSON: that's the only contract
+	// writeReport's "sarif" case actually relies on.
+	if _, err := json.Marshal(log); err != nil {
+		t.Fatalf("json.Marshal(sarifLog): %v", err)
+	}
+}
+
+// TestWriteReportUnknownFormat checks the error path for an unsupported
+// -output-format value.
+func TestWriteReportUnknownFormat(t *testing.T) {
+	dir := t.TempDir()
+	err := writeReport(dir+"/out", "xml", nil, nil)
+	if err == nil {
+		t.Fatal("writeReport with an unknown format = nil error, want one")
+	}
+}
@@ -0,0 +1,239 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// unitchecker.go implements -unitchecker, an alternate entry point
+// compatible with golang.org/x/tools/go/analysis/unitchecker's protocol:
+// instead of -archivefile/-stdlib/positional source files, it reads a
+// single JSON Config describing one package and its dependencies. This is
+// the protocol `go vet -vettool=` drives its tool with, so the same
+// checker binary built for Bazel can also be run as `go vet
+// -vettool=$(which the_checker) ./...`, letting contributors iterate on
+// analyzers without wiring them into a nogo target first.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"golang.org/x/tools/go/gcexportdata"
+)
+
+// unitcheckerConfig is the subset of golang.org/x/tools/go/analysis/unitchecker.Config
+// this driver understands; unrecognized fields in the JSON (Compiler, Dir,
+// GoVersion, IgnoredFiles, ...) are ignored by encoding/json. ID keys the
+// diagnostic tree -json mode writes to stdout. NonGoFiles is threaded
+// through to each Pass as OtherFiles, for analyzers like asmdecl and
+// buildtag that read non-Go sources. Standard is parsed but not yet acted
+// on: no registered analyzer currently needs to know which imports are
+// part of the standard library. VetxOnly means this package is only being
+// analyzed to produce Facts for a downstream package the user actually
+// asked to vet, so its own diagnostics must not be reported.
+type unitcheckerConfig struct {
+	ID                        string
+	ImportPath                string
+	GoFiles                   []string
+	NonGoFiles                []string
+	ImportMap                 map[string]string
+	PackageFile               map[string]string
+	PackageVetx               map[string]string
+	VetxOnly                  bool
+	VetxOutput                string
+	Standard                  map[string]bool
+	SucceedOnTypecheckFailure bool
+}
+
+// unitImporter resolves imports the way a unitcheckerConfig describes: a
+// file may name an import path that ImportMap rewrites (e.g. vendoring)
+// before it's looked up in PackageFile to find the export data archive to
+// read.
+type unitImporter struct {
+	fset        *token.FileSet
+	packages    map[string]*types.Package
+	importMap   map[string]string
+	packageFile map[string]string
+}
+
+func (i *unitImporter) Import(path string) (*types.Package, error) {
+	if path == "unsafe" {
+		return types.Unsafe, nil
+	}
+	if mapped, ok := i.importMap[path]; ok {
+		path = mapped
+	}
+	archive, ok := i.packageFile[path]
+	if !ok {
+		return nil, fmt.Errorf("no archive for import %q", path)
+	}
+	f, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		f.Close()
+		if err != nil {
+			err = fmt.Errorf("reading export data: %s: %v", archive, err)
+		}
+	}()
+
+	r, err := gcexportdata.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	return gcexportdata.Read(r, i.fset, i.packages, path)
+}
+
+// jsonDiagnostic is the per-finding shape cmd/vet expects a unitchecker
+// tool to print to stdout in -json mode.
+type jsonDiagnostic struct {
+	Category string `json:"category,omitempty"`
+	Posn     string `json:"posn"`
+	Message  string `json:"message"`
+}
+
+// unitcheckerTree is the shape -json mode writes to stdout: the package's
+// ID to the name of the Analyzer that reported each diagnostic to its
+// diagnostics. cmd/vet uses the ID to attribute diagnostics back to the
+// unit it asked this process to analyze.
+type unitcheckerTree map[string]map[string][]jsonDiagnostic
+
+// runUnitchecker loads, type-checks, and analyzes the single package
+// cfgPath describes. jsonOutput selects how its diagnostics are reported:
+// see reportUnitcheckerFindings.
+func runUnitchecker(cfgPath string, jsonOutput bool) error {
+	data, err := ioutil.ReadFile(cfgPath)
+	if err != nil {
+		return fmt.Errorf("reading -unitchecker config %s: %v", cfgPath, err)
+	}
+	var cfg unitcheckerConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("parsing -unitchecker config %s: %v", cfgPath, err)
+	}
+
+	fset := token.NewFileSet()
+	imp := &unitImporter{
+		fset:        fset,
+		packages:    make(map[string]*types.Package),
+		importMap:   cfg.ImportMap,
+		packageFile: cfg.PackageFile,
+	}
+
+	apkg, err := load(fset, imp, cfg.GoFiles, cfg.NonGoFiles)
+	if err != nil || apkg == nil {
+		if cfg.SucceedOnTypecheckFailure {
+			return reportUnitcheckerFindings(cfg.ID, nil, jsonOutput, cfg.VetxOnly)
+		}
+		if err != nil {
+			return fmt.Errorf("loading %s: %v", cfg.ImportPath, err)
+		}
+		return fmt.Errorf("loading %s: type-checking failed", cfg.ImportPath)
+	}
+
+	facts, err := importFacts(cfg.PackageVetx, imp.packages)
+	if err != nil {
+		log.Printf("error importing facts: %v", err)
+		facts = newFactSet()
+	}
+
+	findings, err := runAnalyzers(registeredAnalyzers, apkg, facts, nil, "")
+	if err != nil {
+		return fmt.Errorf("running analyzers over %s: %v", cfg.ImportPath, err)
+	}
+
+	if cfg.VetxOutput != "" {
+		if err := facts.encodeTo(cfg.VetxOutput); err != nil {
+			log.Printf("error writing %s: %v", cfg.VetxOutput, err)
+		}
+	}
+
+	return reportUnitcheckerFindings(cfg.ID, findings, jsonOutput, cfg.VetxOnly)
+}
+
+// reportUnitcheckerFindings emits findings the way -json selects: as the
+// unitcheckerTree cmd/vet's -json mode reads from stdout, keyed by
+// packageID, or (the default, matching plain `go vet`) one line per
+// finding to stderr, the way analysisflags.PrintPlain does. Plain-text
+// mode returns an error whenever there were findings, so the process
+// exits non-zero and `go vet` reports the package as failing; -json mode
+// never fails the process, since there the findings are the output to be
+// consumed, not a pass/fail gate. vetxOnly means this package was analyzed
+// only to produce Facts for a downstream package, not because the user
+// asked to vet it, so its diagnostics are dropped instead of reported.
+func reportUnitcheckerFindings(packageID string, findings []finding, jsonOutput, vetxOnly bool) error {
+	if vetxOnly {
+		return nil
+	}
+	if jsonOutput {
+		byAnalyzer := make(map[string][]jsonDiagnostic, len(findings))
+		for _, f := range findings {
+			byAnalyzer[f.analyzer] = append(byAnalyzer[f.analyzer], jsonDiagnostic{
+				Category: f.category,
+				Posn:     f.pos.String(),
+				Message:  f.message,
+			})
+		}
+		return json.NewEncoder(os.Stdout).Encode(unitcheckerTree{packageID: byAnalyzer})
+	}
+
+	if len(findings) == 0 {
+		return nil
+	}
+	for _, f := range findings {
+		fmt.Fprintf(os.Stderr, "%s: [%s] %s\n", f.pos, f.analyzer, f.message)
+	}
+	return fmt.Errorf("%d findings reported analyzing %s", len(findings), packageID)
+}
+
+// unitcheckerFlag is the shape cmd/go/internal/vet's vetFlags expects from
+// a `go vet -vettool=` tool's `-flags` response: the flags it accepts, so
+// cmd/go knows which of its own -vet= flags it can forward.
+type unitcheckerFlag struct {
+	Name  string
+	Bool  bool
+	Usage string
+}
+
+// printUnitcheckerFlags answers the `-flags` preflight `go vet
+// -vettool=` performs before it will invoke this binary for real: it execs
+// `tool -flags` and json.Unmarshals the result, aborting immediately if
+// that isn't valid JSON.
+func printUnitcheckerFlags() error {
+	flags := []unitcheckerFlag{
+		{Name: "json", Bool: true, Usage: "emit -unitchecker diagnostics as the JSON tree cmd/vet expects instead of plain text"},
+	}
+	return json.NewEncoder(os.Stdout).Encode(flags)
+}
+
+// printUnitcheckerVersion answers the `-V=full` preflight `go vet
+// -vettool=` performs alongside -flags: cmd/go's toolID execs `tool
+// -V=full` and uses a sha256 of the binary as its build ID, to decide
+// whether cached vet results are stale. The output must scan as
+// "name version devel ... buildID=<hex>" or cmd/go aborts with "can't
+// parse buildID"; see golang.org/x/tools/go/analysis/internal/analysisflags.
+func printUnitcheckerVersion(arg string) error {
+	if arg != "-V=full" {
+		return fmt.Errorf("unsupported %s, want -V=full", arg)
+	}
+	hash, err := hashExecutable()
+	if err != nil {
+		return fmt.Errorf("-V=full: %v", err)
+	}
+	fmt.Printf("checker version devel buildID=%s\n", hash)
+	return nil
+}
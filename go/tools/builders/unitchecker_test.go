@@ -0,0 +1,222 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/tools/go/analysis/passes/inspect"
+	"golang.org/x/tools/go/analysis/passes/printf"
+)
+
+// captureStdout redirects os.Stdout for the duration of fn and returns what
+// was written to it.
+func captureStdout(t *testing.T, fn func() error) (string, error) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe: %v", err)
+	}
+	old := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = old }()
+
+	fnErr := fn()
+	w.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("reading captured stdout: %v", err)
+	}
+	return string(data), fnErr
+}
+
+// TestPrintUnitcheckerFlags guards against the regression where `checker
+// -flags` printed nothing (a usage error to stderr instead): cmd/go's
+// vetFlags execs `tool -flags` and json.Unmarshals the result before it
+// will invoke the tool at all, so a non-JSON response aborts `go vet
+// -vettool=` immediately.
+func TestPrintUnitcheckerFlags(t *testing.T) {
+	out, err := captureStdout(t, printUnitcheckerFlags)
+	if err != nil {
+		t.Fatalf("printUnitcheckerFlags: %v", err)
+	}
+	var flags []unitcheckerFlag
+	if err := json.Unmarshal([]byte(out), &flags); err != nil {
+		t.Fatalf("-flags output isn't valid JSON: %v\noutput: %s", err, out)
+	}
+	if len(flags) == 0 {
+		t.Error("printUnitcheckerFlags produced an empty flag list")
+	}
+}
+
+// TestReportUnitcheckerFindings covers both output modes: plain text must
+// return an error when there are findings so the process exits non-zero
+// the way `go vet` expects of a failing package, and -json mode must
+// produce the packageID-keyed tree cmd/vet's -json mode reads, never
+// failing the process itself.
+func TestReportUnitcheckerFindings(t *testing.T) {
+	f := finding{analyzer: "printf", message: "bad format verb"}
+
+	if err := reportUnitcheckerFindings("example.com/p", []finding{f}, false, false); err == nil {
+		t.Error("reportUnitcheckerFindings(plain text, findings present) = nil error, want non-nil so go vet sees a failure")
+	}
+	if err := reportUnitcheckerFindings("example.com/p", nil, false, false); err != nil {
+		t.Errorf("reportUnitcheckerFindings(plain text, no findings) = %v, want nil", err)
+	}
+
+	out, err := captureStdout(t, func() error {
+		return reportUnitcheckerFindings("example.com/p", []finding{f}, true, false)
+	})
+	if err != nil {
+		t.Fatalf("reportUnitcheckerFindings(-json) returned an error: %v", err)
+	}
+	var tree unitcheckerTree
+	if err := json.Unmarshal([]byte(out), &tree); err != nil {
+		t.Fatalf("-json output isn't valid JSON: %v\noutput: %s", err, out)
+	}
+	diags := tree["example.com/p"]["printf"]
+	if len(diags) != 1 || diags[0].Message != f.message {
+		t.Errorf("tree = %+v, want one %q diagnostic with message %q", tree, f.analyzer, f.message)
+	}
+}
+
+// TestReportUnitcheckerFindingsVetxOnly guards the VetxOnly short-circuit:
+// a package analyzed only to produce Facts for a downstream package must
+// never report its own findings or fail the process, in either output mode.
+func TestReportUnitcheckerFindingsVetxOnly(t *testing.T) {
+	f := finding{analyzer: "printf", message: "bad format verb"}
+
+	if err := reportUnitcheckerFindings("example.com/p", []finding{f}, false, true); err != nil {
+		t.Errorf("reportUnitcheckerFindings(plain text, vetxOnly) = %v, want nil", err)
+	}
+
+	out, err := captureStdout(t, func() error {
+		return reportUnitcheckerFindings("example.com/p", []finding{f}, true, true)
+	})
+	if err != nil {
+		t.Fatalf("reportUnitcheckerFindings(-json, vetxOnly) returned an error: %v", err)
+	}
+	if out != "" {
+		t.Errorf("reportUnitcheckerFindings(-json, vetxOnly) wrote %q to stdout, want nothing", out)
+	}
+}
+
+// TestUnitImporterImportsUnsafe guards against the regression where
+// unitImporter.Import consulted PackageFile before recognizing the
+// "unsafe" pseudo-package, so it always failed with `no archive for
+// import "unsafe"` even though unsafe has no archive to look up.
+func TestUnitImporterImportsUnsafe(t *testing.T) {
+	imp := &unitImporter{packages: make(map[string]*types.Package)}
+	pkg, err := imp.Import("unsafe")
+	if err != nil {
+		t.Fatalf("Import(\"unsafe\") = %v, want types.Unsafe", err)
+	}
+	if pkg != types.Unsafe {
+		t.Errorf("Import(\"unsafe\") = %v, want types.Unsafe", pkg)
+	}
+}
+
+var registerVetAnalyzersOnce sync.Once
+
+// registerVetAnalyzers wires up printf (and its inspect.Analyzer
+// dependency) the way generate_checker_main.go's generated init does, so
+// runUnitchecker has an Analyzer to run.
+func registerVetAnalyzers() {
+	registerVetAnalyzersOnce.Do(func() {
+		register(inspect.Analyzer)
+		register(printf.Analyzer)
+	})
+}
+
+// fmtExportFile locates the gcexportdata archive the installed go tool
+// already built for the "fmt" package, standing in for the PackageFile
+// entry `go vet -vettool=` would supply for a real dependency.
+func fmtExportFile(t *testing.T) string {
+	t.Helper()
+	out, err := exec.Command("go", "list", "-export", "-f", "{{.Export}}", "fmt").Output()
+	if err != nil {
+		t.Skipf("go list -export fmt: %v", err)
+	}
+	path := strings.TrimSpace(string(out))
+	if path == "" {
+		t.Skip("go list -export fmt produced no archive")
+	}
+	return path
+}
+
+// TestRunUnitcheckerPackageImportingUnsafe is an end-to-end regression
+// test for the common case this request exists to support: a package
+// that imports "unsafe" (extremely common for low-level/reflect-based
+// code) must still type-check and have its real findings reported,
+// rather than load returning nil and runUnitchecker failing the whole
+// package with "type-checking failed" before any analyzer ever ran.
+func TestRunUnitcheckerPackageImportingUnsafe(t *testing.T) {
+	registerVetAnalyzers()
+	fmtArchive := fmtExportFile(t)
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "p.go")
+	if err := ioutil.WriteFile(src, []byte(`package p
+
+import (
+	"fmt"
+	_ "unsafe"
+)
+
+func F() {
+	fmt.Printf("%d", "not a number")
+}
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := unitcheckerConfig{
+		ID:          "p",
+		ImportPath:  "p",
+		GoFiles:     []string{src},
+		PackageFile: map[string]string{"fmt": fmtArchive},
+	}
+	cfgData, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfgPath := filepath.Join(dir, "vet.cfg")
+	if err := ioutil.WriteFile(cfgPath, cfgData, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := captureStdout(t, func() error {
+		return runUnitchecker(cfgPath, true)
+	})
+	if err != nil {
+		t.Fatalf("runUnitchecker(package importing unsafe) = %v, want the package to load and its printf finding reported via -json", err)
+	}
+	var tree unitcheckerTree
+	if jsonErr := json.Unmarshal([]byte(out), &tree); jsonErr != nil {
+		t.Fatalf("-json output isn't valid JSON: %v\noutput: %s", jsonErr, out)
+	}
+	if diags := tree["p"]["printf"]; len(diags) == 0 {
+		t.Fatalf("tree = %+v, want at least one printf diagnostic for the deliberate fmt.Printf(%%d, string) bug", tree)
+	}
+}